@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	bleEnabled         = flag.Bool("ble", false, "If true, scan for Ruuvi advertisements directly over BLE instead of relying solely on the HTTP endpoints")
+	bleDevice          = flag.String("ble_device", "hci0", "HCI device to use for direct BLE scanning")
+	bleAllowDuplicates = flag.Bool("ble_allow_duplicates", true, "If true, report every advertisement seen rather than only the first one per scan")
+)
+
+// ruuviManufacturerID is Ruuvi Innovations Ltd's Bluetooth SIG manufacturer
+// ID, see BluetoothAdvertisement.Manufacturer.
+const ruuviManufacturerID = 0x0499
+
+var (
+	bleScanErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ruuvi_ble_scan_errors_total",
+	}, []string{"device"})
+	bleDroppedPackets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ruuvi_ble_dropped_packets_total",
+	}, []string{"device"})
+	bleResets = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ruuvi_ble_hci_resets_total",
+	}, []string{"device"})
+)
+
+func init() {
+	prometheus.MustRegister(bleScanErrors)
+	prometheus.MustRegister(bleDroppedPackets)
+	prometheus.MustRegister(bleResets)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// bleSource scans for BLE advertisements on a local HCI adapter and reports
+// any Ruuvi manufacturer-specific advertisement it sees.
+type bleSource struct {
+	device string
+}
+
+// newBLESource returns a Source scanning on the given local HCI device (e.g.
+// "hci0"). The device itself is only opened when Run is called.
+func newBLESource(device string) (Source, error) {
+	return &bleSource{device: device}, nil
+}
+
+func (b *bleSource) Run(ctx context.Context, out chan<- Advertisement) error {
+	dev, err := linux.NewDeviceWithName(b.device)
+	if err != nil {
+		bleResets.With(prometheus.Labels{"device": b.device}).Inc()
+		return fmt.Errorf("unable to open HCI device %q: %w", b.device, err)
+	}
+	defer dev.Stop()
+	ble.SetDefaultDevice(dev)
+
+	handler := func(a ble.Advertisement) {
+		raw := a.ManufacturerData()
+		if len(raw) < 2 || binary.LittleEndian.Uint16(raw[:2]) != ruuviManufacturerID {
+			return
+		}
+		adv := Advertisement{
+			MacAddr: a.Addr().String(),
+			RSSI:    int64(a.RSSI()),
+			Data:    buildRuuviAdvHex(raw[2:]),
+		}
+		select {
+		case out <- adv:
+		default:
+			bleDroppedPackets.With(prometheus.Labels{"device": b.device}).Inc()
+		}
+	}
+
+	if err := ble.Scan(ctx, *bleAllowDuplicates, handler, nil); err != nil && ctx.Err() == nil {
+		bleScanErrors.With(prometheus.Labels{"device": b.device}).Inc()
+		return err
+	}
+	return nil
+}
+
+// buildRuuviAdvHex reconstructs the hex-encoded advertisement expected by
+// decodeBluetoothData (flags + length + type + manufacturer + Ruuvi
+// payload) from the manufacturer-specific bytes reported by the BLE stack,
+// which only exposes the payload after the manufacturer ID.
+func buildRuuviAdvHex(payload []byte) string {
+	buf := []byte{0x02, 0x01, 0x04, byte(len(payload) + 3), 0xff, 0x99, 0x04}
+	buf = append(buf, payload...)
+	return fmt.Sprintf("%x", buf)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// bleSource is the non-Linux stand-in: direct BLE scanning relies on the
+// HCI transport from github.com/go-ble/ble, which is Linux-only.
+type bleSource struct{}
+
+func newBLESource(device string) (Source, error) {
+	return nil, fmt.Errorf("direct BLE scanning (--ble) is only supported on linux")
+}
+
+func (b *bleSource) Run(ctx context.Context, out chan<- Advertisement) error {
+	return fmt.Errorf("direct BLE scanning (--ble) is only supported on linux")
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var (
+	datalogPath      = flag.String("datalog", "", "If set, path to a SQLite database file in which to keep a historical log of every measurement, e.g. /var/lib/ruuvi.sqlite")
+	datalogRetention = flag.Duration("datalog_retention", 90*24*time.Hour, "How long to keep rows in --datalog before pruning them")
+)
+
+// datalogColumns lists the measurement fields stored as their own SQLite
+// column, in table/CSV/JSON order.
+var datalogColumns = []string{
+	"temperature", "humidity", "pressure",
+	"accelx", "accely", "accelz",
+	"voltage", "txpower", "rssi",
+	"movementcounter", "measurementsequencenumber",
+}
+
+// datalogSink records every measurement it sees into a SQLite database, so
+// history survives past whatever retention window Prometheus/InfluxDB/MQTT
+// keep, and can be queried later without a separate TSDB.
+type datalogSink struct {
+	db *sql.DB
+}
+
+// newDatalogSink opens (creating if needed) a SQLite database at path, in
+// WAL mode to allow concurrent reads while writing.
+func newDatalogSink(path string) (*datalogSink, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %q: %w", path, err)
+	}
+	for _, pragma := range []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA synchronous=NORMAL",
+	} {
+		if _, err := db.Exec(pragma); err != nil {
+			return nil, fmt.Errorf("setting up %q: %w", path, err)
+		}
+	}
+	const schema = `
+CREATE TABLE IF NOT EXISTS measurements (
+	ts REAL NOT NULL,
+	id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	temperature REAL,
+	humidity REAL,
+	pressure REAL,
+	accelx REAL,
+	accely REAL,
+	accelz REAL,
+	voltage REAL,
+	txpower REAL,
+	rssi REAL,
+	movementcounter REAL,
+	measurementsequencenumber REAL,
+	raw_hex TEXT
+);
+CREATE INDEX IF NOT EXISTS measurements_ts_idx ON measurements(ts);
+CREATE INDEX IF NOT EXISTS measurements_id_idx ON measurements(id);
+`
+	if _, err := db.Exec(schema); err != nil {
+		return nil, fmt.Errorf("creating schema in %q: %w", path, err)
+	}
+	return &datalogSink{db: db}, nil
+}
+
+func (d *datalogSink) Publish(ctx context.Context, m Measurement) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO measurements (ts, id, name, temperature, humidity, pressure, accelx, accely, accelz, voltage, txpower, rssi, movementcounter, measurementsequencenumber, raw_hex)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		float64(m.Time.Unix()), m.ID, m.Name,
+		m.Fields["temperature"], m.Fields["humidity"], m.Fields["pressure"],
+		m.Fields["accelx"], m.Fields["accely"], m.Fields["accelz"],
+		m.Fields["voltage"], m.Fields["txpower"], m.Fields["rssi"],
+		m.Fields["movementcounter"], m.Fields["measurementsequencenumber"],
+		m.Raw)
+	return err
+}
+
+// prune deletes rows whose timestamp is before the given time. Called
+// periodically by runRetentionLoop.
+func (d *datalogSink) prune(ctx context.Context, before time.Time) error {
+	_, err := d.db.ExecContext(ctx, `DELETE FROM measurements WHERE ts < ?`, float64(before.Unix()))
+	return err
+}
+
+// runRetentionLoop prunes rows older than retention every interval, until
+// ctx is done.
+func (d *datalogSink) runRetentionLoop(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.prune(ctx, time.Now().Add(-retention)); err != nil {
+				fmt.Printf("datalog: prune error: %v\n", err)
+			}
+		}
+	}
+}
+
+// datalogQuery builds the SELECT and its arguments for the given
+// /datalog.csv or /datalog.json query string.
+func datalogQuery(r *http.Request) (string, []interface{}, error) {
+	query := "SELECT ts, id, name, temperature, humidity, pressure, accelx, accely, accelz, voltage, txpower, rssi, movementcounter, measurementsequencenumber, raw_hex FROM measurements WHERE 1=1"
+	var args []interface{}
+
+	if since := r.URL.Query().Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid since=%q, want RFC3339: %w", since, err)
+		}
+		query += " AND ts >= ?"
+		args = append(args, float64(t.Unix()))
+	}
+	if id := r.URL.Query().Get("id"); id != "" {
+		query += " AND id = ?"
+		args = append(args, id)
+	}
+	query += " ORDER BY ts"
+	return query, args, nil
+}
+
+// datalogRow mirrors one row of the measurements table, for JSON export.
+type datalogRow struct {
+	Time                      float64 `json:"ts"`
+	ID                        string  `json:"id"`
+	Name                      string  `json:"name"`
+	Temperature               float64 `json:"temperature"`
+	Humidity                  float64 `json:"humidity"`
+	Pressure                  float64 `json:"pressure"`
+	AccelX                    float64 `json:"accelx"`
+	AccelY                    float64 `json:"accely"`
+	AccelZ                    float64 `json:"accelz"`
+	Voltage                   float64 `json:"voltage"`
+	TxPower                   float64 `json:"txpower"`
+	RSSI                      float64 `json:"rssi"`
+	MovementCounter           float64 `json:"movementcounter"`
+	MeasurementSequenceNumber float64 `json:"measurementsequencenumber"`
+	RawHex                    string  `json:"raw_hex"`
+}
+
+func (d *datalogSink) scanRows(rows *sql.Rows) ([]datalogRow, error) {
+	var out []datalogRow
+	for rows.Next() {
+		var row datalogRow
+		if err := rows.Scan(&row.Time, &row.ID, &row.Name, &row.Temperature, &row.Humidity, &row.Pressure,
+			&row.AccelX, &row.AccelY, &row.AccelZ, &row.Voltage, &row.TxPower, &row.RSSI,
+			&row.MovementCounter, &row.MeasurementSequenceNumber, &row.RawHex); err != nil {
+			return nil, err
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// ServeCSV implements GET /datalog.csv?since=<RFC3339>&id=<tag id>.
+func (d *datalogSink) ServeCSV(w http.ResponseWriter, r *http.Request) {
+	query, args, err := datalogQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rows, err := d.db.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	records, err := d.scanRows(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	cw := csv.NewWriter(w)
+	header := append([]string{"ts", "id", "name"}, datalogColumns...)
+	header = append(header, "raw_hex")
+	cw.Write(header)
+	for _, row := range records {
+		cw.Write([]string{
+			strconv.FormatFloat(row.Time, 'f', -1, 64),
+			row.ID, row.Name,
+			strconv.FormatFloat(row.Temperature, 'f', -1, 64),
+			strconv.FormatFloat(row.Humidity, 'f', -1, 64),
+			strconv.FormatFloat(row.Pressure, 'f', -1, 64),
+			strconv.FormatFloat(row.AccelX, 'f', -1, 64),
+			strconv.FormatFloat(row.AccelY, 'f', -1, 64),
+			strconv.FormatFloat(row.AccelZ, 'f', -1, 64),
+			strconv.FormatFloat(row.Voltage, 'f', -1, 64),
+			strconv.FormatFloat(row.TxPower, 'f', -1, 64),
+			strconv.FormatFloat(row.RSSI, 'f', -1, 64),
+			strconv.FormatFloat(row.MovementCounter, 'f', -1, 64),
+			strconv.FormatFloat(row.MeasurementSequenceNumber, 'f', -1, 64),
+			row.RawHex,
+		})
+	}
+	cw.Flush()
+}
+
+// ServeJSON implements GET /datalog.json?since=<RFC3339>&id=<tag id>.
+func (d *datalogSink) ServeJSON(w http.ResponseWriter, r *http.Request) {
+	query, args, err := datalogQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rows, err := d.db.QueryContext(r.Context(), query, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	records, err := d.scanRows(rows)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
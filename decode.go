@@ -0,0 +1,469 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+)
+
+// DecodedMeasurement is the common set of values every supported Ruuvi data
+// format (3, 5, 8, 0xC5) can populate, regardless of its on-the-wire
+// encoding, so callers don't need to special-case a particular format.
+type DecodedMeasurement struct {
+	FormatVersion byte
+
+	TemperatureCelsius float64
+	HumidityPercent    float64
+	PressurePa         float64
+
+	AccelXG float64
+	AccelYG float64
+	AccelZG float64
+
+	VoltageVolts float64
+	// TxPowerDBm is the transmit power, above -40dBm in 2dBm steps, when
+	// the format carries it. 0 for formats that don't (3, 0xC5).
+	TxPowerDBm float64
+
+	MovementCounter byte
+	MeasureSequence uint16
+}
+
+// byteReader is a small cursor over a byte slice, shared by every format
+// parser below to consume fixed-width big/little-endian fields.
+type byteReader struct {
+	data []byte
+	idx  int
+}
+
+func newByteReader(data []byte) *byteReader {
+	return &byteReader{data: data, idx: -1}
+}
+
+func (r *byteReader) consumeByte() (byte, error) {
+	r.idx++
+	if len(r.data) <= r.idx {
+		return 0, fmt.Errorf("not enough data for index %d", r.idx)
+	}
+	return r.data[r.idx], nil
+}
+
+func (r *byteReader) consumeBytes(n int) ([]byte, error) {
+	out := make([]byte, n)
+	for i := range out {
+		b, err := r.consumeByte()
+		if err != nil {
+			return nil, err
+		}
+		out[i] = b
+	}
+	return out, nil
+}
+
+// consumeBEuint16 reads a true big-endian (first byte is the most
+// significant one) 16bit value, as used by format 3/5/8's fields and the
+// CRC16 trailer.
+func (r *byteReader) consumeBEuint16() (uint16, error) {
+	b1, err := r.consumeByte()
+	if err != nil {
+		return 0, err
+	}
+	b2, err := r.consumeByte()
+	if err != nil {
+		return 0, err
+	}
+	return uint16(b2) + 256*uint16(b1), nil
+}
+
+func (r *byteReader) consumeBEint16() (int16, error) {
+	u, err := r.consumeBEuint16()
+	return int16(u), err
+}
+
+// consumeLEuint16 reads a true little-endian (first byte is the least
+// significant one) 16bit value, as used by the manufacturer ID field.
+func (r *byteReader) consumeLEuint16() (uint16, error) {
+	b1, err := r.consumeByte()
+	if err != nil {
+		return 0, err
+	}
+	b2, err := r.consumeByte()
+	if err != nil {
+		return 0, err
+	}
+	return uint16(b1) + 256*uint16(b2), nil
+}
+
+func (r *byteReader) consumeLEint16() (int16, error) {
+	u, err := r.consumeLEuint16()
+	return int16(u), err
+}
+
+// remaining returns the bytes not yet consumed.
+func (r *byteReader) remaining() []byte {
+	return r.data[r.idx+1:]
+}
+
+// DataFormat3 represents the decoded values of a format 3 (RAWv1) message.
+// https://docs.ruuvi.com/communication/bluetooth-advertisements/data-format-3-rawv1
+type DataFormat3 struct {
+	// 0x3
+	FormatVersion byte
+	// Humidity in 0.5% per LSB
+	Humidity byte
+	// Temperature sign (bit 7) and magnitude in degrees (bits 0-6) of the
+	// integer byte.
+	TemperatureSign bool
+	TemperatureInt  byte
+	// Temperature fractional part, hundredths of a degree.
+	TemperatureFrac byte
+	// Pressure (16bit unsigned, big-endian) in 1 Pa units, with offset of
+	// -50000 Pa, same convention as format 5.
+	Pressure uint16
+
+	// Acceleration, in milli-G, big-endian.
+	AccelX int16
+	AccelY int16
+	AccelZ int16
+
+	// Battery voltage, in millivolts, big-endian.
+	Battery uint16
+}
+
+func (d *DataFormat3) TemperatureInCelsius() float64 {
+	t := float64(d.TemperatureInt) + float64(d.TemperatureFrac)/100
+	if d.TemperatureSign {
+		t = -t
+	}
+	return t
+}
+
+func (d *DataFormat3) PressureInPa() float64 {
+	return float64(d.Pressure) + 50000
+}
+
+func (d *DataFormat3) HumidityInPercent() float64 {
+	return float64(d.Humidity) * 0.5
+}
+
+func (d *DataFormat3) AccelXInG() float64 { return float64(d.AccelX) / 1000.0 }
+func (d *DataFormat3) AccelYInG() float64 { return float64(d.AccelY) / 1000.0 }
+func (d *DataFormat3) AccelZInG() float64 { return float64(d.AccelZ) / 1000.0 }
+
+func (d *DataFormat3) VoltageInVolts() float64 {
+	return float64(d.Battery) / 1000.0
+}
+
+func (d *DataFormat3) toMeasurement() DecodedMeasurement {
+	return DecodedMeasurement{
+		FormatVersion:      d.FormatVersion,
+		TemperatureCelsius: d.TemperatureInCelsius(),
+		HumidityPercent:    d.HumidityInPercent(),
+		PressurePa:         d.PressureInPa(),
+		AccelXG:            d.AccelXInG(),
+		AccelYG:            d.AccelYInG(),
+		AccelZG:            d.AccelZInG(),
+		VoltageVolts:       d.VoltageInVolts(),
+	}
+}
+
+// parseFormat3 parses a format 3 (RAWv1) payload; r must be positioned at
+// the start of the payload (format byte first).
+func parseFormat3(r *byteReader) (*DataFormat3, error) {
+	var d DataFormat3
+	var err error
+
+	if d.FormatVersion, err = r.consumeByte(); err != nil {
+		return nil, err
+	}
+	if got, want := d.FormatVersion, byte(3); got != want {
+		return nil, fmt.Errorf("got format version %d, wanted %d", got, want)
+	}
+	if d.Humidity, err = r.consumeByte(); err != nil {
+		return nil, err
+	}
+	tByte, err := r.consumeByte()
+	if err != nil {
+		return nil, err
+	}
+	d.TemperatureSign = tByte&0x80 != 0
+	d.TemperatureInt = tByte & 0x7f
+	if d.TemperatureFrac, err = r.consumeByte(); err != nil {
+		return nil, err
+	}
+	if d.Pressure, err = r.consumeBEuint16(); err != nil {
+		return nil, err
+	}
+	if d.AccelX, err = r.consumeBEint16(); err != nil {
+		return nil, err
+	}
+	if d.AccelY, err = r.consumeBEint16(); err != nil {
+		return nil, err
+	}
+	if d.AccelZ, err = r.consumeBEint16(); err != nil {
+		return nil, err
+	}
+	if d.Battery, err = r.consumeBEuint16(); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// parseFormat5 parses a format 5 (RAWv2) payload; r must be positioned at
+// the start of the payload (format byte first).
+func parseFormat5(r *byteReader) (*DataFormat5, error) {
+	var d DataFormat5
+	var err error
+
+	if d.FormatVersion, err = r.consumeByte(); err != nil {
+		return nil, err
+	}
+	if got, want := d.FormatVersion, byte(5); got != want {
+		return nil, fmt.Errorf("got format version %d, wanted %d", got, want)
+	}
+	if d.Temperature, err = r.consumeBEint16(); err != nil {
+		return nil, err
+	}
+	if d.Humidity, err = r.consumeBEuint16(); err != nil {
+		return nil, err
+	}
+	if d.Pressure, err = r.consumeBEuint16(); err != nil {
+		return nil, err
+	}
+	if d.AccelX, err = r.consumeBEint16(); err != nil {
+		return nil, err
+	}
+	if d.AccelY, err = r.consumeBEint16(); err != nil {
+		return nil, err
+	}
+	if d.AccelZ, err = r.consumeBEint16(); err != nil {
+		return nil, err
+	}
+	if d.CodedPower, err = r.consumeBEuint16(); err != nil {
+		return nil, err
+	}
+	d.Voltage = (d.CodedPower >> 5) & (1<<11 - 1)
+	d.TxPower = d.CodedPower & (1<<5 - 1)
+	if d.MovementCounter, err = r.consumeByte(); err != nil {
+		return nil, err
+	}
+	if d.MeasureSequence, err = r.consumeBEuint16(); err != nil {
+		return nil, err
+	}
+	for i := 0; i < 6; i++ {
+		if d.MacAddress[i], err = r.consumeByte(); err != nil {
+			return nil, err
+		}
+	}
+	return &d, nil
+}
+
+func (d *DataFormat5) toMeasurement() DecodedMeasurement {
+	return DecodedMeasurement{
+		FormatVersion:      d.FormatVersion,
+		TemperatureCelsius: d.TemperatureInCelsius(),
+		HumidityPercent:    d.HumidityInPercent(),
+		PressurePa:         d.PressureInPa(),
+		AccelXG:            d.AccelXInG(),
+		AccelYG:            d.AccelYInG(),
+		AccelZG:            d.AccelZInG(),
+		VoltageVolts:       d.VoltageInVolts(),
+		TxPowerDBm:         float64(d.TxPower)*2 - 40,
+		MovementCounter:    d.MovementCounter,
+		MeasureSequence:    d.MeasureSequence,
+	}
+}
+
+// parseFormat8 decodes an encrypted RAWv2 message: a 4-byte device header
+// (opaque, carried in clear so the receiver knows which key to use), an
+// AES-128-CTR encrypted body with the same layout as a format 5 payload
+// (minus its format byte and trailing MAC address), a 4-byte nonce used to
+// build the CTR IV, and a trailing CRC16/CCITT covering everything that
+// came before it. r must be positioned at the start of the payload (format
+// byte first); key is the tag's 16-byte AES key.
+func parseFormat8(r *byteReader, key []byte) (*DataFormat5, error) {
+	format, err := r.consumeByte()
+	if err != nil {
+		return nil, err
+	}
+	if got, want := format, byte(8); got != want {
+		return nil, fmt.Errorf("got format version %d, wanted %d", got, want)
+	}
+	if _, err := r.consumeBytes(4); err != nil { // device header, unused for decryption
+		return nil, fmt.Errorf("reading device header: %w", err)
+	}
+
+	const bodyLen = 17 // temp(2)+humidity(2)+pressure(2)+accel(3*2)+codedPower(2)+movement(1)+measureSeq(2)
+	body, err := r.consumeBytes(bodyLen)
+	if err != nil {
+		return nil, fmt.Errorf("reading encrypted body: %w", err)
+	}
+	nonce, err := r.consumeBytes(4)
+	if err != nil {
+		return nil, fmt.Errorf("reading nonce: %w", err)
+	}
+	crc, err := r.consumeBEuint16()
+	if err != nil {
+		return nil, fmt.Errorf("reading CRC: %w", err)
+	}
+	if got, want := crc, crc16CCITT(r.data[:r.idx-1]); got != want {
+		return nil, fmt.Errorf("CRC mismatch: got 0x%x, computed 0x%x", got, want)
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("format 8 (encrypted) requires a configured per-tag key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("building AES cipher: %w", err)
+	}
+	// The cipher's IV must be 16 bytes; derive it by zero-padding the
+	// transmitted 4-byte nonce.
+	iv := make([]byte, aes.BlockSize)
+	copy(iv, nonce)
+	plain := make([]byte, len(body))
+	cipher.NewCTR(block, iv).XORKeyStream(plain, body)
+
+	br := newByteReader(plain)
+	var d DataFormat5
+	d.FormatVersion = 8
+	if d.Temperature, err = br.consumeBEint16(); err != nil {
+		return nil, err
+	}
+	if d.Humidity, err = br.consumeBEuint16(); err != nil {
+		return nil, err
+	}
+	if d.Pressure, err = br.consumeBEuint16(); err != nil {
+		return nil, err
+	}
+	if d.AccelX, err = br.consumeBEint16(); err != nil {
+		return nil, err
+	}
+	if d.AccelY, err = br.consumeBEint16(); err != nil {
+		return nil, err
+	}
+	if d.AccelZ, err = br.consumeBEint16(); err != nil {
+		return nil, err
+	}
+	if d.CodedPower, err = br.consumeBEuint16(); err != nil {
+		return nil, err
+	}
+	d.Voltage = (d.CodedPower >> 5) & (1<<11 - 1)
+	d.TxPower = d.CodedPower & (1<<5 - 1)
+	if d.MovementCounter, err = br.consumeByte(); err != nil {
+		return nil, err
+	}
+	if d.MeasureSequence, err = br.consumeBEuint16(); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// DataFormatC5 represents the decoded values of the compact 11-byte "C5"
+// format: format byte, 16-bit temperature, 8-bit humidity, 8-bit pressure,
+// 8-bit battery, 8-bit movement counter, 16-bit measurement sequence and a
+// trailing 16-bit CRC16/CCITT over the preceding bytes, with coarser precision than format 5 in exchange for a much shorter
+// payload.
+type DataFormatC5 struct {
+	// 0xC5
+	FormatVersion byte
+	// Temperature in 0.1 degrees, signed.
+	Temperature int16
+	// Humidity in 0.5% per LSB.
+	Humidity byte
+	// Pressure (8bit unsigned) in 100 Pa (1hPa) units, with offset of
+	// 50000 Pa.
+	Pressure byte
+	// Battery voltage, in 10mV units above 1.6V.
+	Battery byte
+	// MovementCounter increments on every detected motion, wrapping at 255.
+	MovementCounter byte
+	// MeasureSequence increments on every measurement, wrapping at 65535;
+	// lets consumers detect lost advertisements.
+	MeasureSequence uint16
+}
+
+func (d *DataFormatC5) TemperatureInCelsius() float64 {
+	return float64(d.Temperature) * 0.1
+}
+
+func (d *DataFormatC5) HumidityInPercent() float64 {
+	return float64(d.Humidity) * 0.5
+}
+
+func (d *DataFormatC5) PressureInPa() float64 {
+	return float64(d.Pressure)*100 + 50000
+}
+
+func (d *DataFormatC5) VoltageInVolts() float64 {
+	return 1.6 + float64(d.Battery)*0.01
+}
+
+func (d *DataFormatC5) toMeasurement() DecodedMeasurement {
+	return DecodedMeasurement{
+		FormatVersion:      d.FormatVersion,
+		TemperatureCelsius: d.TemperatureInCelsius(),
+		HumidityPercent:    d.HumidityInPercent(),
+		PressurePa:         d.PressureInPa(),
+		VoltageVolts:       d.VoltageInVolts(),
+		MovementCounter:    d.MovementCounter,
+		MeasureSequence:    d.MeasureSequence,
+	}
+}
+
+// parseFormatC5 parses a compact format 0xC5 payload; r must be positioned
+// at the start of the payload (format byte first).
+func parseFormatC5(r *byteReader) (*DataFormatC5, error) {
+	var d DataFormatC5
+	var err error
+
+	if d.FormatVersion, err = r.consumeByte(); err != nil {
+		return nil, err
+	}
+	if got, want := d.FormatVersion, byte(0xc5); got != want {
+		return nil, fmt.Errorf("got format version 0x%x, wanted 0x%x", got, want)
+	}
+	if d.Temperature, err = r.consumeBEint16(); err != nil {
+		return nil, err
+	}
+	if d.Humidity, err = r.consumeByte(); err != nil {
+		return nil, err
+	}
+	if d.Pressure, err = r.consumeByte(); err != nil {
+		return nil, err
+	}
+	if d.Battery, err = r.consumeByte(); err != nil {
+		return nil, err
+	}
+	if d.MovementCounter, err = r.consumeByte(); err != nil {
+		return nil, err
+	}
+	if d.MeasureSequence, err = r.consumeBEuint16(); err != nil {
+		return nil, err
+	}
+	crc, err := r.consumeBEuint16()
+	if err != nil {
+		return nil, err
+	}
+	if got, want := crc, crc16CCITT(r.data[:len(r.data)-2]); got != want {
+		return nil, fmt.Errorf("CRC mismatch: got 0x%x, computed 0x%x", got, want)
+	}
+	return &d, nil
+}
+
+// crc16CCITT computes the CRC16/CCITT-FALSE checksum (polynomial 0x1021,
+// initial value 0xFFFF) used to validate format 8 and 0xC5 payloads.
+func crc16CCITT(data []byte) uint16 {
+	var crc uint16 = 0xffff
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
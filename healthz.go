@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var maxStaleness = flag.Duration("max_staleness", 10*time.Minute, "A tag, and the server as a whole via /healthz, is considered stale if no measurement was seen for longer than this")
+
+// tagStaleness lets operators alert on a tag going silent, independently of
+// whether /healthz as a whole is still passing (e.g. a single dead tag
+// among many healthy ones).
+var tagStaleness = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ruuvi_tag_staleness_seconds",
+}, []string{"name", "id"})
+
+func init() {
+	prometheus.MustRegister(tagStaleness)
+}
+
+// tagSeen records the last time a tag was heard from, and under what name,
+// for staleness tracking.
+type tagSeen struct {
+	name string
+	at   time.Time
+}
+
+// markTagSeen records that a measurement for id was just published, marking
+// the server ready and resetting that tag's staleness clock.
+func (s *Server) markTagSeen(id, name string, t time.Time) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.tagLastSeen == nil {
+		s.tagLastSeen = make(map[string]tagSeen)
+	}
+	s.tagLastSeen[id] = tagSeen{name: name, at: t}
+	s.ready = true
+}
+
+// serveHealthz returns 200 if at least one tag has been updated within
+// --max_staleness, 503 otherwise.
+func (s *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	for _, seen := range s.tagLastSeen {
+		if now.Sub(seen.at) <= *maxStaleness {
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintln(w, "ok")
+			return
+		}
+	}
+	http.Error(w, "no tag has reported within max_staleness", http.StatusServiceUnavailable)
+}
+
+// serveReadyz returns 200 once the server has accepted its first valid
+// payload, 503 until then.
+func (s *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	ready := s.ready
+	s.m.Unlock()
+
+	if !ready {
+		http.Error(w, "not ready: no payload accepted yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+// runStalenessLoop periodically refreshes ruuvi_tag_staleness_seconds for
+// every known tag, until ctx is done.
+func (s *Server) runStalenessLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.updateStalenessGauge()
+		}
+	}
+}
+
+func (s *Server) updateStalenessGauge() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	now := time.Now()
+	for id, seen := range s.tagLastSeen {
+		tagStaleness.With(prometheusLabels(seen.name, id)).Set(now.Sub(seen.at).Seconds())
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InfluxDB2Config configures the "influxdb2" sink.
+type InfluxDB2Config struct {
+	// ServerURL is the InfluxDB 2.x API endpoint, e.g. "http://localhost:8086".
+	ServerURL string `yaml:"server_url"`
+	// Token is an InfluxDB 2.x API token with write access to Bucket.
+	Token string `yaml:"token"`
+	// Org is the InfluxDB organization name.
+	Org string `yaml:"org"`
+	// Bucket is the destination bucket name.
+	Bucket string `yaml:"bucket"`
+	// Measurement is the InfluxDB measurement name to write points under.
+	// Defaults to "ruuvi".
+	Measurement string `yaml:"measurement"`
+}
+
+var influxWriteErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "ruuvi_influxdb2_write_errors_total",
+})
+
+func init() {
+	prometheus.MustRegister(influxWriteErrors)
+}
+
+// influxDB2Sink publishes measurements to InfluxDB 2.x using the async
+// WriteAPI, which batches points in the background.
+type influxDB2Sink struct {
+	client      influxdb2.Client
+	writeAPI    api.WriteAPI
+	measurement string
+}
+
+func newInfluxDB2Sink(cfg *InfluxDB2Config) (*influxDB2Sink, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("influxdb2 sink enabled but no `influxdb2:` config section provided")
+	}
+	if cfg.ServerURL == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("influxdb2 sink requires server_url and bucket to be set")
+	}
+
+	measurement := cfg.Measurement
+	if measurement == "" {
+		measurement = "ruuvi"
+	}
+
+	client := influxdb2.NewClient(cfg.ServerURL, cfg.Token)
+	writeAPI := client.WriteAPI(cfg.Org, cfg.Bucket)
+
+	sink := &influxDB2Sink{
+		client:      client,
+		writeAPI:    writeAPI,
+		measurement: measurement,
+	}
+	go func() {
+		for err := range writeAPI.Errors() {
+			influxWriteErrors.Inc()
+			fmt.Printf("influxdb2 write error: %v\n", err)
+		}
+	}()
+	return sink, nil
+}
+
+func (s *influxDB2Sink) Publish(ctx context.Context, m Measurement) error {
+	tags := map[string]string{"name": m.Name, "id": m.ID}
+	for k, v := range m.Tags {
+		tags[k] = v
+	}
+
+	fields := make(map[string]interface{}, len(m.Fields))
+	for k, v := range m.Fields {
+		fields[k] = v
+	}
+
+	point := influxdb2.NewPoint(s.measurement, tags, fields, m.Time)
+	s.writeAPI.WritePoint(point)
+	return nil
+}
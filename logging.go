@@ -0,0 +1,38 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	logLevel  = flag.String("log_level", "info", "Minimum log level to emit: debug, info, warn or error")
+	logFormat = flag.String("log_format", "console", "Log encoding to use: console or json")
+)
+
+// logger is the process-wide structured logger. It defaults to a no-op
+// logger so code paths exercised outside of main() don't need a nil check;
+// main() replaces it once flags are parsed.
+var logger = zap.NewNop()
+
+// newLogger builds the process-wide structured logger from --log_level and
+// --log_format.
+func newLogger() (*zap.Logger, error) {
+	var level zapcore.Level
+	if err := level.Set(*logLevel); err != nil {
+		return nil, fmt.Errorf("invalid --log_level %q: %w", *logLevel, err)
+	}
+
+	cfg := zap.NewProductionConfig()
+	if *logFormat == "console" {
+		cfg = zap.NewDevelopmentConfig()
+	} else if *logFormat != "json" {
+		return nil, fmt.Errorf("invalid --log_format %q, want console or json", *logFormat)
+	}
+	cfg.Level = zap.NewAtomicLevelAt(level)
+
+	return cfg.Build()
+}
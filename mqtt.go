@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"text/template"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MQTTConfig configures the "mqtt" sink.
+type MQTTConfig struct {
+	// BrokerURL is the broker to connect to, e.g. "tcp://localhost:1883"
+	// or "tls://localhost:8883".
+	BrokerURL string `yaml:"broker_url"`
+	ClientID  string `yaml:"client_id"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+	QoS       byte   `yaml:"qos"`
+	Retain    bool   `yaml:"retain"`
+
+	// CACert, ClientCert and ClientKey are PEM file paths used to validate
+	// a self-signed broker (or mutually authenticate to one) when
+	// BrokerURL uses the tls:// scheme. All are optional.
+	CACert     string `yaml:"ca_cert"`
+	ClientCert string `yaml:"client_cert"`
+	ClientKey  string `yaml:"client_key"`
+	// InsecureSkipVerify disables broker certificate validation. Only
+	// meant for testing against a broker with a temporary/self-issued cert.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+
+	// Topic is a text/template topic string, evaluated per measurement
+	// with access to its Name/ID/Fields/Tags fields. Defaults to
+	// "ruuvi/{{.Name}}/state".
+	Topic string `yaml:"topic"`
+
+	// Discovery, when true, publishes a Home Assistant MQTT Discovery
+	// config message the first time a tag is seen, so its sensors show
+	// up automatically.
+	Discovery bool `yaml:"discovery"`
+	// DiscoveryPrefix is HA's discovery topic prefix. Defaults to
+	// "homeassistant".
+	DiscoveryPrefix string `yaml:"discovery_prefix"`
+}
+
+var mqttPublishErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "ruuvi_mqtt_publish_errors_total",
+})
+
+func init() {
+	prometheus.MustRegister(mqttPublishErrors)
+}
+
+// haDiscoveryMetrics lists the measurement fields that get a Home Assistant
+// MQTT Discovery sensor, along with their HA device_class/unit.
+var haDiscoveryMetrics = []struct {
+	Field       string
+	DeviceClass string
+	Unit        string
+}{
+	{"temperature", "temperature", "°C"},
+	{"humidity", "humidity", "%"},
+	{"pressure", "pressure", "hPa"},
+	{"voltage", "voltage", "V"},
+}
+
+// mqttSink publishes measurements as JSON to an MQTT broker, with optional
+// Home Assistant MQTT Discovery.
+type mqttSink struct {
+	cfg      *MQTTConfig
+	client   mqtt.Client
+	topicTpl *template.Template
+
+	mu        sync.Mutex
+	announced map[string]bool
+}
+
+func newMQTTSink(cfg *MQTTConfig) (*mqttSink, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("mqtt sink enabled but no `mqtt:` config section provided")
+	}
+	if cfg.BrokerURL == "" {
+		return nil, fmt.Errorf("mqtt sink requires broker_url to be set")
+	}
+
+	topic := cfg.Topic
+	if topic == "" {
+		topic = "ruuvi/{{.Name}}/state"
+	}
+	topicTpl, err := template.New("topic").Parse(topic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mqtt topic template %q: %w", topic, err)
+	}
+
+	opts := mqtt.NewClientOptions().AddBroker(cfg.BrokerURL)
+	if cfg.ClientID != "" {
+		opts.SetClientID(cfg.ClientID)
+	}
+	if cfg.Username != "" {
+		opts.SetUsername(cfg.Username)
+		opts.SetPassword(cfg.Password)
+	}
+	if cfg.CACert != "" || cfg.ClientCert != "" || cfg.InsecureSkipVerify {
+		tlsConfig, err := mqttTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("unable to connect to mqtt broker %q: %w", cfg.BrokerURL, token.Error())
+	}
+
+	return &mqttSink{
+		cfg:       cfg,
+		client:    client,
+		topicTpl:  topicTpl,
+		announced: make(map[string]bool),
+	}, nil
+}
+
+// mqttTLSConfig builds the tls.Config for connecting to a broker that
+// uses a self-signed certificate and/or requires mutual TLS, per cfg's
+// ca_cert/client_cert/client_key/insecure_skip_verify settings.
+func mqttTLSConfig(cfg *MQTTConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read mqtt ca_cert %q: %w", cfg.CACert, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in mqtt ca_cert %q", cfg.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		if cfg.ClientCert == "" || cfg.ClientKey == "" {
+			return nil, fmt.Errorf("mqtt client_cert and client_key must both be set for mutual TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load mqtt client_cert/client_key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func (s *mqttSink) topicFor(m Measurement) (string, error) {
+	var buf bytes.Buffer
+	if err := s.topicTpl.Execute(&buf, m); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (s *mqttSink) Publish(ctx context.Context, m Measurement) error {
+	if s.cfg.Discovery {
+		s.maybeAnnounce(m)
+	}
+
+	topic, err := s.topicFor(m)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(m.Fields)
+	if err != nil {
+		return err
+	}
+	token := s.client.Publish(topic, s.cfg.QoS, s.cfg.Retain, payload)
+	if token.Wait() && token.Error() != nil {
+		mqttPublishErrors.Inc()
+		return token.Error()
+	}
+	return nil
+}
+
+// maybeAnnounce publishes Home Assistant MQTT Discovery config messages the
+// first time a given tag ID is seen.
+func (s *mqttSink) maybeAnnounce(m Measurement) {
+	s.mu.Lock()
+	if s.announced[m.ID] {
+		s.mu.Unlock()
+		return
+	}
+	s.announced[m.ID] = true
+	s.mu.Unlock()
+
+	stateTopic, err := s.topicFor(m)
+	if err != nil {
+		fmt.Printf("mqtt discovery: unable to compute state topic for %q: %v\n", m.ID, err)
+		return
+	}
+
+	prefix := s.cfg.DiscoveryPrefix
+	if prefix == "" {
+		prefix = "homeassistant"
+	}
+
+	for _, dm := range haDiscoveryMetrics {
+		if _, ok := m.Fields[dm.Field]; !ok {
+			continue
+		}
+		uniqueID := fmt.Sprintf("%s_%s", m.ID, dm.Field)
+		cfgTopic := fmt.Sprintf("%s/sensor/%s/config", prefix, uniqueID)
+		cfgPayload, err := json.Marshal(map[string]interface{}{
+			"name":                tagDiscoveryName(m.Name, dm.Field),
+			"unique_id":           uniqueID,
+			"state_topic":         stateTopic,
+			"value_template":      fmt.Sprintf("{{ value_json.%s }}", dm.Field),
+			"unit_of_measurement": dm.Unit,
+			"device_class":        dm.DeviceClass,
+			"device": map[string]interface{}{
+				"identifiers":  []string{m.ID},
+				"name":         m.Name,
+				"manufacturer": "Ruuvi Innovations",
+			},
+		})
+		if err != nil {
+			fmt.Printf("mqtt discovery: unable to marshal config for %q: %v\n", uniqueID, err)
+			continue
+		}
+		token := s.client.Publish(cfgTopic, s.cfg.QoS, true, cfgPayload)
+		if token.Wait() && token.Error() != nil {
+			fmt.Printf("mqtt discovery: publish error for %q: %v\n", uniqueID, token.Error())
+		}
+	}
+}
+
+func tagDiscoveryName(tagName, field string) string {
+	return tagName + " " + field
+}
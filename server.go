@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"flag"
@@ -19,6 +20,7 @@ import (
 	"github.com/goccy/go-yaml"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
 )
 
 var (
@@ -26,6 +28,7 @@ var (
 	debug          = flag.Bool("debug", false, "If true, export info about what was submitted")
 	configFilename = flag.String("config", "", "YAML configuration file to use, optional")
 	decodeData     = flag.String("decode_data", "", "Decode the provide bluetooth advertised data encoded in hex and exit. For debugging.")
+	decodeMac      = flag.String("decode_mac", "", "MAC address to look up in --config for the decryption key, when decoding a format 8 (encrypted) --decode_data payload.")
 )
 
 var (
@@ -68,6 +71,12 @@ var (
 	}, []string{"name", "id"})
 )
 
+// prometheusLabels builds the {name, id} label set shared by every
+// per-tag Prometheus metric.
+func prometheusLabels(name, id string) prometheus.Labels {
+	return prometheus.Labels{"name": name, "id": id}
+}
+
 func init() {
 	tagMetrics = map[string]*prometheus.GaugeVec{}
 	for _, name := range tagMetricsNames {
@@ -183,7 +192,15 @@ type BluetoothAdvertisement struct {
 	// Raw payload
 	Payload []byte
 
+	// Data5 holds the decoded format 5 (RAWv2) fields, populated only
+	// when Measurement.FormatVersion == 5. Kept for backward-compatible
+	// debugging (see --decode_data); exporters should use Measurement
+	// instead, which every supported format populates.
 	Data5 DataFormat5
+
+	// Measurement holds the values decoded from whichever data format
+	// this advertisement used (3, 5, 8 or 0xC5).
+	Measurement DecodedMeasurement
 }
 
 // DataFormat5 represents the decoded values of a format 5 message.
@@ -250,144 +267,101 @@ func (d *DataFormat5) VoltageInVolts() float64 {
 	return 1.6 + float64(d.Voltage)/1000
 }
 
-func decodeBluetoothData(raw string) (*BluetoothAdvertisement, error) {
+// decodeBluetoothData parses a raw, hex-encoded Bluetooth advertisement and
+// dispatches on its Ruuvi data format (the first payload byte) to decode
+// its measurements. macAddr and cfgPerTag are only needed for encrypted
+// formats, to look up the tag's decryption key; "" / nil may be passed when
+// that context is not available. --decode_data passes --decode_mac and the
+// tags from --config.
+func decodeBluetoothData(macAddr string, raw string, cfgPerTag map[string]*ConfigTagInfo) (*BluetoothAdvertisement, error) {
 	decoded, err := hex.DecodeString(raw)
 	if err != nil {
 		return nil, err
 	}
 
-	lastIdx := -1
-	consumeByte := func() (byte, error) {
-		lastIdx++
-		if len(decoded) <= lastIdx {
-			return 0, fmt.Errorf("not enough data for index %d", lastIdx)
-		}
-		return decoded[lastIdx], nil
-	}
-	// Big endian
-	consumeBEuint16 := func() (uint16, error) {
-		b1, err := consumeByte()
-		if err != nil {
-			return 0, err
-		}
-		b2, err := consumeByte()
-		if err != nil {
-			return 0, err
-		}
-		return uint16(b1) + 256*uint16(b2), nil
-	}
-	// Little endian
-	consumeLEuint16 := func() (uint16, error) {
-		b1, err := consumeByte()
-		if err != nil {
-			return 0, err
-		}
-		b2, err := consumeByte()
-		if err != nil {
-			return 0, err
-		}
-		return 256*uint16(b1) + uint16(b2), nil
-	}
-	// Little endian
-	consumeLEint16 := func() (int16, error) {
-		b1, err := consumeByte()
-		if err != nil {
-			return 0, err
-		}
-		b2, err := consumeByte()
-		if err != nil {
-			return 0, err
-		}
-		return 256*int16(b1) + int16(b2), nil
-	}
-
+	r := newByteReader(decoded)
 	var adv BluetoothAdvertisement
 
 	// Parse flags
-	if adv.Flags[0], err = consumeByte(); err != nil {
+	if adv.Flags[0], err = r.consumeByte(); err != nil {
 		return nil, err
 	}
-	if adv.Flags[1], err = consumeByte(); err != nil {
+	if adv.Flags[1], err = r.consumeByte(); err != nil {
 		return nil, err
 	}
-	if adv.Flags[2], err = consumeByte(); err != nil {
+	if adv.Flags[2], err = r.consumeByte(); err != nil {
 		return nil, err
 	}
 
 	// Parse length
-	if adv.Length, err = consumeByte(); err != nil {
+	if adv.Length, err = r.consumeByte(); err != nil {
 		return nil, err
 	}
-	if got, want := adv.Length, byte(27); got != want {
-		return nil, fmt.Errorf("got 0x%x at index %d, wanted 0x%x", got, lastIdx, want)
-	}
 
 	// Parse type
-	if adv.Type, err = consumeByte(); err != nil {
+	if adv.Type, err = r.consumeByte(); err != nil {
 		return nil, err
 	}
 	if got, want := adv.Type, byte(0xff); got != want {
-		return nil, fmt.Errorf("got 0x%x at index %d, wanted 0x%x", got, lastIdx, want)
+		return nil, fmt.Errorf("got 0x%x at index %d, wanted 0x%x", got, r.idx, want)
 	}
 
-	// Parse manufacturer
-	if adv.Manufacturer, err = consumeBEuint16(); err != nil {
+	// Parse manufacturer. It is transmitted least significant byte
+	// first, so this needs the little-endian reader, not the big-endian
+	// one used by the format 3/8/0xC5 fields below.
+	if adv.Manufacturer, err = r.consumeLEuint16(); err != nil {
 		return nil, err
 	}
 	if got, want := adv.Manufacturer, uint16(0x0499); got != want {
 		return nil, fmt.Errorf("got manufacturer ID 0x%x, wanted 0x%x", got, want)
 	}
 
-	// Get the rest of the payload
-	// That does not advance lastIdx - we're just doing a copy here.
-	adv.Payload = decoded[lastIdx+1:]
+	// Get the rest of the payload.
+	adv.Payload = decoded[r.idx+1:]
 	if got, want := len(adv.Payload)+3, int(adv.Length); got != want {
 		return nil, fmt.Errorf("got %d bytes for payload, while length indicates %d", got, want)
 	}
-
-	// Decode format v5
-	if adv.Data5.FormatVersion, err = consumeByte(); err != nil {
-		return nil, err
-	}
-	if got, want := adv.Data5.FormatVersion, byte(5); got != want {
-		return nil, fmt.Errorf("got format version %d, wanted %d", got, want)
-	}
-
-	if adv.Data5.Temperature, err = consumeLEint16(); err != nil {
-		return nil, err
-	}
-	if adv.Data5.Humidity, err = consumeLEuint16(); err != nil {
-		return nil, err
-	}
-	if adv.Data5.Pressure, err = consumeLEuint16(); err != nil {
-		return nil, err
-	}
-	if adv.Data5.AccelX, err = consumeLEint16(); err != nil {
-		return nil, err
-	}
-	if adv.Data5.AccelY, err = consumeLEint16(); err != nil {
-		return nil, err
-	}
-	if adv.Data5.AccelZ, err = consumeLEint16(); err != nil {
-		return nil, err
+	if len(adv.Payload) == 0 {
+		return nil, fmt.Errorf("empty payload, missing data format byte")
 	}
 
-	if adv.Data5.CodedPower, err = consumeLEuint16(); err != nil {
-		return nil, err
-	}
-	adv.Data5.Voltage = (adv.Data5.CodedPower >> 5) & (1<<11 - 1)
-	adv.Data5.TxPower = (adv.Data5.CodedPower) & (1<<5 - 1)
-	if adv.Data5.MovementCounter, err = consumeByte(); err != nil {
-		return nil, err
-	}
-
-	if adv.Data5.MeasureSequence, err = consumeLEuint16(); err != nil {
-		return nil, err
-	}
-	for i := 0; i < 6; i++ {
-		if adv.Data5.MacAddress[i], err = consumeByte(); err != nil {
-			return nil, err
+	// Dispatch on the Ruuvi data format.
+	pr := newByteReader(adv.Payload)
+	switch format := adv.Payload[0]; format {
+	case 5:
+		data5, err := parseFormat5(pr)
+		if err != nil {
+			return nil, fmt.Errorf("format 5: %w", err)
+		}
+		adv.Data5 = *data5
+		adv.Measurement = data5.toMeasurement()
+	case 3:
+		data3, err := parseFormat3(pr)
+		if err != nil {
+			return nil, fmt.Errorf("format 3: %w", err)
+		}
+		adv.Measurement = data3.toMeasurement()
+	case 8:
+		var key []byte
+		if tagCfg := cfgPerTag[macAddr]; tagCfg != nil {
+			key, err = tagCfg.aesKey()
+			if err != nil {
+				return nil, fmt.Errorf("format 8: %w", err)
+			}
+		}
+		data8, err := parseFormat8(pr, key)
+		if err != nil {
+			return nil, fmt.Errorf("format 8: %w", err)
 		}
+		adv.Measurement = data8.toMeasurement()
+	case 0xc5:
+		dataC5, err := parseFormatC5(pr)
+		if err != nil {
+			return nil, fmt.Errorf("format 0xc5: %w", err)
+		}
+		adv.Measurement = dataC5.toMeasurement()
+	default:
+		return nil, fmt.Errorf("unsupported Ruuvi data format 0x%x", format)
 	}
 
 	return &adv, nil
@@ -397,6 +371,17 @@ func decodeBluetoothData(raw string) (*BluetoothAdvertisement, error) {
 type Config struct {
 	// Gives override per tag. Keyed by the ID of the tag.
 	Tags []*ConfigTagInfo `yaml:"tags"`
+
+	// Sinks lists which output sinks measurements should be published to,
+	// e.g. `[prometheus, influxdb2]`. Defaults to just `prometheus` when
+	// empty, to match this server's historical behavior.
+	Sinks []string `yaml:"sinks"`
+
+	// InfluxDB2 configures the "influxdb2" sink, when enabled.
+	InfluxDB2 *InfluxDB2Config `yaml:"influxdb2"`
+
+	// MQTT configures the "mqtt" sink, when enabled.
+	MQTT *MQTTConfig `yaml:"mqtt"`
 }
 
 // ConfigTagInfo contains configuration per tag.
@@ -406,28 +391,61 @@ type ConfigTagInfo struct {
 
 	// If not empty, use this name instead of the one provided by Ruuvi Station.
 	Name string `yaml:"name"`
+
+	// Tags are extra key/value pairs attached to every measurement for
+	// this tag, for sinks that support tagging (e.g. influxdb2).
+	Tags map[string]string `yaml:"tags"`
+
+	// Key is the tag's AES-128 decryption key, hex encoded (32 hex
+	// characters), required to decode format 8 (encrypted RAWv2)
+	// advertisements from this tag.
+	Key string `yaml:"key"`
+}
+
+// aesKey decodes Key into its 16 raw bytes.
+func (c *ConfigTagInfo) aesKey() ([]byte, error) {
+	if c.Key == "" {
+		return nil, fmt.Errorf("no decryption key configured for tag %q", c.ID)
+	}
+	key, err := hex.DecodeString(c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key for tag %q: %w", c.ID, err)
+	}
+	if len(key) != 16 {
+		return nil, fmt.Errorf("key for tag %q must be 16 bytes (32 hex characters), got %d bytes", c.ID, len(key))
+	}
+	return key, nil
 }
 
 // Server takes care of receiving measures and export them back.
 type Server struct {
 	cfgPerTag map[string]*ConfigTagInfo
+	sinks     []Sink
 
 	m                 sync.Mutex
 	lastRaw           []byte
 	lastStationParsed *StationInfo
 	lastGatewayParsed *GatewayInfo
+	tagLastSeen       map[string]tagSeen
+	ready             bool
 }
 
 // New creates a new server.
-func New(cfg *Config) *Server {
+func New(cfg *Config) (*Server, error) {
+	sinks, err := newSinks(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	s := &Server{
 		cfgPerTag: make(map[string]*ConfigTagInfo),
+		sinks:     sinks,
 	}
 	for _, tagCfg := range cfg.Tags {
 		s.cfgPerTag[tagCfg.ID] = tagCfg
-		fmt.Printf("Mapping %q to %q\n", tagCfg.ID, tagCfg.Name)
+		logger.Info("mapping tag", zap.String("id", tagCfg.ID), zap.String("name", tagCfg.Name))
 	}
-	return s
+	return s, nil
 }
 
 // receive implements the endpoint receiving requests from the Ruuvi
@@ -435,7 +453,7 @@ func New(cfg *Config) *Server {
 func (s *Server) receive(_ http.ResponseWriter, r *http.Request) {
 	raw, err := io.ReadAll(r.Body)
 	if err != nil {
-		fmt.Printf("Read body error: %v\n", err)
+		logger.Warn("read body error", zap.Error(err))
 		return
 	}
 	s.m.Lock()
@@ -446,13 +464,13 @@ func (s *Server) receive(_ http.ResponseWriter, r *http.Request) {
 	stationInfo := &StationInfo{}
 	err = json.Unmarshal(raw, stationInfo)
 	if err != nil {
-		fmt.Printf("Station unmarshal error: %v\n", err)
+		logger.Debug("station unmarshal error", zap.Error(err))
 		stationInfo = nil
 	}
 	gatewayInfo := &GatewayInfo{}
 	err = json.Unmarshal(raw, gatewayInfo)
 	if err != nil {
-		fmt.Printf("Gateway unmarshal error: %v\n", err)
+		logger.Debug("gateway unmarshal error", zap.Error(err))
 		gatewayInfo = nil
 	}
 
@@ -475,86 +493,128 @@ func (s *Server) receive(_ http.ResponseWriter, r *http.Request) {
 
 func (s *Server) exportStationInfo(stationInfo *StationInfo) {
 	for _, tag := range stationInfo.Tags {
+		tagCfg := s.cfgPerTag[tag.ID]
 		tagName := tag.Name
-		if s.cfgPerTag[tag.ID] != nil && s.cfgPerTag[tag.ID].Name != "" {
-			tagName = s.cfgPerTag[tag.ID].Name
+		if tagCfg != nil && tagCfg.Name != "" {
+			tagName = tagCfg.Name
 		}
 		if *debug {
 			fmt.Printf("Tag %s: id=%q name=%q temp=%f pressure=%f humidity=%f\n", tagName, tag.ID, tag.Name, tag.Temperature, tag.Pressure, tag.Humidity)
 		}
 
 		v := reflect.ValueOf(tag)
+		fields := make(map[string]float64, len(tagMetricsNames))
 		for _, metricName := range tagMetricsNames {
 			// Generic fields attached to the tag.
 			fv := v.FieldByNameFunc(func(fname string) bool {
 				return strings.ToLower(fname) == metricName
 			})
-			var f float64
 			if fv.Kind() == reflect.Int64 {
-				f = float64(fv.Int())
+				fields[metricName] = float64(fv.Int())
 			} else {
-				f = fv.Float()
+				fields[metricName] = fv.Float()
 			}
-			tagMetrics[metricName].With(prometheus.Labels{"name": tagName, "id": tag.ID}).Set(f)
-
-			// Export updated time.
-			var err error
-			var t time.Time
-			for _, timeFormat := range []string{"2006-01-02T15:04:05-0700", "2006-01-02T15:04:05-07:00"} {
-				t, err = time.Parse(timeFormat, tag.UpdateAt)
-				if err == nil {
-					break
-				}
-			}
-			if err != nil {
-				fmt.Printf("Unable to parse %q: %v\n", tag.UpdateAt, err)
-			} else {
-				tagUpdateAt.With(prometheus.Labels{"name": tagName, "id": tag.ID}).Set(float64(t.Unix()))
+		}
+
+		var updateAt time.Time
+		var err error
+		for _, timeFormat := range []string{"2006-01-02T15:04:05-0700", "2006-01-02T15:04:05-07:00"} {
+			updateAt, err = time.Parse(timeFormat, tag.UpdateAt)
+			if err == nil {
+				break
 			}
+		}
+		if err != nil {
+			logger.Warn("unable to parse tag update time", zap.String("updateAt", tag.UpdateAt), zap.Error(err))
+			updateAt = time.Now()
+		}
 
-			// Export station info for each tag.
-			tagStationBatteryLevel.With(prometheus.Labels{"name": tagName, "id": tag.ID}).Set(float64(stationInfo.BatteryLevel))
-			tagStationLocationAccuracy.With(prometheus.Labels{"name": tagName, "id": tag.ID}).Set(stationInfo.Location.Accuracy)
-			tagStationLocationLatitude.With(prometheus.Labels{"name": tagName, "id": tag.ID}).Set(stationInfo.Location.Latitude)
-			tagStationLocationLongitude.With(prometheus.Labels{"name": tagName, "id": tag.ID}).Set(stationInfo.Location.Longitude)
+		var tagTags map[string]string
+		if tagCfg != nil {
+			tagTags = tagCfg.Tags
 		}
+		s.publish(context.Background(), Measurement{
+			Name:   tagName,
+			ID:     tag.ID,
+			Time:   updateAt,
+			Fields: fields,
+			Tags:   tagTags,
+			Raw:    stationBlobHex(tag.RawDataBlob),
+		})
+
+		// Station-specific info, not part of the generic Measurement
+		// shape shared with BLE/Gateway sources.
+		labels := prometheusLabels(tagName, tag.ID)
+		tagStationBatteryLevel.With(labels).Set(float64(stationInfo.BatteryLevel))
+		tagStationLocationAccuracy.With(labels).Set(stationInfo.Location.Accuracy)
+		tagStationLocationLatitude.With(labels).Set(stationInfo.Location.Latitude)
+		tagStationLocationLongitude.With(labels).Set(stationInfo.Location.Longitude)
 	}
 }
 
 func (s *Server) exportGatewayInfo(gatewayInfo *GatewayInfo) {
 	for macAddr, tag := range gatewayInfo.Data.Tags {
-		adv, err := decodeBluetoothData(tag.Data)
-		if err != nil {
-			fmt.Printf("unable to decode tag %s, data %s: %v", macAddr, tag.Data, err)
-			continue
-		}
+		s.handleAdvertisement(macAddr, tag.RSSI, tag.Data)
+	}
+}
 
-		tagName := macAddr
-		if s.cfgPerTag[macAddr] != nil && s.cfgPerTag[macAddr].Name != "" {
-			tagName = s.cfgPerTag[macAddr].Name
-		}
+// handleAdvertisement decodes a single raw advertisement and publishes its
+// measurements to the configured sinks. It is the common path for both
+// Gateway HTTP pushes and advertisements coming from a direct BLE Source;
+// Station pushes go through exportStationInfo instead.
+func (s *Server) handleAdvertisement(macAddr string, rssi int64, data string) {
+	adv, err := decodeBluetoothData(macAddr, data, s.cfgPerTag)
+	if err != nil {
+		logger.Warn("unable to decode tag", zap.String("mac", macAddr), zap.String("data", data), zap.Error(err))
+		return
+	}
 
-		temperature := adv.Data5.TemperatureInCelsius()
-		pressure := adv.Data5.PressureInPa()
-		humidity := adv.Data5.HumidityInPercent()
+	tagCfg := s.cfgPerTag[macAddr]
+	tagName := macAddr
+	if tagCfg != nil && tagCfg.Name != "" {
+		tagName = tagCfg.Name
+	}
 
-		if *debug {
-			fmt.Printf("Tag %s: mac=%q temp=%f pressure=%f humidity=%f\n", tagName, macAddr, temperature, pressure, humidity)
-		}
+	m := adv.Measurement
+	if *debug {
+		fmt.Printf("Tag %s: mac=%q temp=%f pressure=%f humidity=%f\n", tagName, macAddr, m.TemperatureCelsius, m.PressurePa, m.HumidityPercent)
+	}
+
+	var tagTags map[string]string
+	if tagCfg != nil {
+		tagTags = tagCfg.Tags
+	}
+	s.publish(context.Background(), Measurement{
+		Name: tagName,
+		ID:   macAddr,
+		Time: time.Now(),
+		Fields: map[string]float64{
+			"temperature":               m.TemperatureCelsius,
+			"pressure":                  m.PressurePa,
+			"humidity":                  m.HumidityPercent,
+			"accelx":                    m.AccelXG,
+			"accely":                    m.AccelYG,
+			"accelz":                    m.AccelZG,
+			"voltage":                   m.VoltageVolts,
+			"txpower":                   m.TxPowerDBm,
+			"rssi":                      float64(rssi),
+			"dataformat":                float64(m.FormatVersion),
+			"movementcounter":           float64(m.MovementCounter),
+			"measurementsequencenumber": float64(m.MeasureSequence),
+		},
+		Tags: tagTags,
+		Raw:  data,
+	})
+}
 
-		tagMetrics["temperature"].With(prometheus.Labels{"name": tagName, "id": macAddr}).Set(temperature)
-		tagMetrics["pressure"].With(prometheus.Labels{"name": tagName, "id": macAddr}).Set(pressure)
-		tagMetrics["humidity"].With(prometheus.Labels{"name": tagName, "id": macAddr}).Set(humidity)
-		tagMetrics["accelx"].With(prometheus.Labels{"name": tagName, "id": macAddr}).Set(adv.Data5.AccelXInG())
-		tagMetrics["accely"].With(prometheus.Labels{"name": tagName, "id": macAddr}).Set(adv.Data5.AccelYInG())
-		tagMetrics["accelz"].With(prometheus.Labels{"name": tagName, "id": macAddr}).Set(adv.Data5.AccelZInG())
-		tagMetrics["voltage"].With(prometheus.Labels{"name": tagName, "id": macAddr}).Set(adv.Data5.VoltageInVolts())
-		tagMetrics["txpower"].With(prometheus.Labels{"name": tagName, "id": macAddr}).Set(float64(adv.Data5.TxPower))
-		tagMetrics["rssi"].With(prometheus.Labels{"name": tagName, "id": macAddr}).Set(float64(tag.RSSI))
-		tagMetrics["dataformat"].With(prometheus.Labels{"name": tagName, "id": macAddr}).Set(float64(adv.Data5.FormatVersion))
-		tagMetrics["movementcounter"].With(prometheus.Labels{"name": tagName, "id": macAddr}).Set(float64(adv.Data5.MovementCounter))
-		tagMetrics["measurementsequencenumber"].With(prometheus.Labels{"name": tagName, "id": macAddr}).Set(float64(adv.Data5.MeasureSequence))
+// stationBlobHex hex-encodes a StationBlob's raw sensor bytes, for storage
+// alongside decoded measurements.
+func stationBlobHex(blob StationBlob) string {
+	raw := make([]byte, len(blob.Blob))
+	for i, b := range blob.Blob {
+		raw[i] = byte(b)
 	}
+	return hex.EncodeToString(raw)
 }
 
 // Serve .
@@ -606,8 +666,30 @@ var indexDebugTpl = template.Must(template.New("index").Parse(`
 func main() {
 	flag.Parse()
 
+	var err error
+	logger, err = newLogger()
+	if err != nil {
+		log.Fatalf("Unable to set up logging: %v", err)
+	}
+	defer logger.Sync()
+
+	cfg := &Config{}
+	if *configFilename != "" {
+		raw, err := os.ReadFile(*configFilename)
+		if err != nil {
+			logger.Fatal("unable to read config", zap.String("path", *configFilename), zap.Error(err))
+		}
+		if err := yaml.Unmarshal(raw, cfg); err != nil {
+			logger.Fatal("unable to parse config", zap.String("path", *configFilename), zap.Error(err))
+		}
+	}
+
 	if *decodeData != "" {
-		adv, err := decodeBluetoothData(*decodeData)
+		cfgPerTag := make(map[string]*ConfigTagInfo)
+		for _, tagCfg := range cfg.Tags {
+			cfgPerTag[tagCfg.ID] = tagCfg
+		}
+		adv, err := decodeBluetoothData(*decodeMac, *decodeData, cfgPerTag)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "decoding failure: %v\n", err)
 		}
@@ -615,28 +697,46 @@ func main() {
 		return
 	}
 
-	fmt.Println("Ruuvi gateway server")
+	logger.Info("Ruuvi gateway server starting")
 	http.Handle("/metrics", promhttp.Handler())
 
-	cfg := &Config{}
-	if *configFilename != "" {
-		raw, err := os.ReadFile(*configFilename)
+	s, err := New(cfg)
+	if err != nil {
+		logger.Fatal("unable to create server", zap.Error(err))
+	}
+	http.HandleFunc("/", s.Serve)
+	http.HandleFunc("/healthz", s.serveHealthz)
+	http.HandleFunc("/readyz", s.serveReadyz)
+	go s.runStalenessLoop(context.Background(), 10*time.Second)
+
+	if *datalogPath != "" {
+		datalog, err := newDatalogSink(*datalogPath)
 		if err != nil {
-			log.Fatalf("Unable to read %q: %v", *configFilename, err)
-		}
-		if err := yaml.Unmarshal(raw, cfg); err != nil {
-			log.Fatalf("Unable to read %q: %v", *configFilename, err)
+			logger.Fatal("unable to open datalog", zap.String("path", *datalogPath), zap.Error(err))
 		}
+		s.addSink(datalog)
+		go datalog.runRetentionLoop(context.Background(), *datalogRetention, time.Hour)
+		http.HandleFunc("/datalog.csv", datalog.ServeCSV)
+		http.HandleFunc("/datalog.json", datalog.ServeJSON)
 	}
 
-	s := New(cfg)
-	http.HandleFunc("/", s.Serve)
+	if *bleEnabled {
+		src, err := newBLESource(*bleDevice)
+		if err != nil {
+			logger.Fatal("unable to start BLE scan", zap.String("device", *bleDevice), zap.Error(err))
+		}
+		go func() {
+			if err := s.runSource(context.Background(), src); err != nil {
+				logger.Warn("BLE source stopped", zap.String("device", *bleDevice), zap.Error(err))
+			}
+		}()
+	}
 
 	addr := fmt.Sprintf(":%d", *port)
 	hostname, err := os.Hostname()
 	if err != nil {
 		hostname = ""
 	}
-	fmt.Printf("Listening on http://%s%s\n", hostname, addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	logger.Info("Listening", zap.String("url", fmt.Sprintf("http://%s%s", hostname, addr)))
+	logger.Fatal("server stopped", zap.Error(http.ListenAndServe(addr, nil)))
 }
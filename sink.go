@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Measurement is a single set of tag readings, ready to be published to
+// whichever Sinks are configured. Field names match tagMetricsNames.
+type Measurement struct {
+	// Name is the (possibly overridden) human readable name of the tag.
+	Name string
+	// ID is the tag's stable identifier (its MAC address).
+	ID string
+	// Time is when the measurement was taken.
+	Time time.Time
+	// Fields holds one entry per metric in tagMetricsNames that could be
+	// extracted for this measurement.
+	Fields map[string]float64
+	// Tags carries extra key/value pairs to attach to the measurement,
+	// e.g. for sinks that support per-tag tagging (see ConfigTagInfo.Tags).
+	Tags map[string]string
+	// Raw is the hex-encoded source payload the measurement was decoded
+	// from, if any. Used by the datalog sink to keep the original bytes
+	// around for later re-analysis.
+	Raw string
+}
+
+// Sink publishes measurements to some external system - Prometheus gauges,
+// a time-series database, a message broker, ...
+type Sink interface {
+	// Publish exports a single measurement. It should not block
+	// indefinitely; slow sinks are expected to buffer internally.
+	Publish(ctx context.Context, m Measurement) error
+}
+
+// addSink appends an extra sink, for integrations driven by a command line
+// flag rather than the `sinks:` config list (e.g. --datalog).
+func (s *Server) addSink(sink Sink) {
+	s.sinks = append(s.sinks, sink)
+}
+
+// publish sends m to every configured sink, logging (but not stopping on)
+// individual failures, and marks the tag as seen for staleness/readiness
+// tracking.
+func (s *Server) publish(ctx context.Context, m Measurement) {
+	s.markTagSeen(m.ID, m.Name, m.Time)
+	for _, sink := range s.sinks {
+		if err := sink.Publish(ctx, m); err != nil {
+			logger.Warn("sink publish error", zap.String("id", m.ID), zap.Error(err))
+		}
+	}
+}
+
+// newSinks builds the list of Sinks to use, based on the `sinks:` config
+// entry. It defaults to just Prometheus when unset, to match the server's
+// prior behavior of always exporting to /metrics.
+func newSinks(cfg *Config) ([]Sink, error) {
+	names := cfg.Sinks
+	if len(names) == 0 {
+		names = []string{"prometheus"}
+	}
+
+	var sinks []Sink
+	for _, name := range names {
+		switch name {
+		case "prometheus":
+			sinks = append(sinks, newPrometheusSink())
+		case "influxdb2":
+			sink, err := newInfluxDB2Sink(cfg.InfluxDB2)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create influxdb2 sink: %w", err)
+			}
+			sinks = append(sinks, sink)
+		case "mqtt":
+			sink, err := newMQTTSink(cfg.MQTT)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create mqtt sink: %w", err)
+			}
+			sinks = append(sinks, sink)
+		default:
+			return nil, fmt.Errorf("unknown sink %q", name)
+		}
+	}
+	return sinks, nil
+}
+
+// prometheusSink publishes measurements through the existing tagMetrics
+// gauges, preserving the server's original behavior.
+type prometheusSink struct{}
+
+func newPrometheusSink() *prometheusSink {
+	return &prometheusSink{}
+}
+
+func (p *prometheusSink) Publish(ctx context.Context, m Measurement) error {
+	labels := prometheusLabels(m.Name, m.ID)
+	for name, value := range m.Fields {
+		gauge, ok := tagMetrics[name]
+		if !ok {
+			continue
+		}
+		gauge.With(labels).Set(value)
+	}
+	tagUpdateAt.With(labels).Set(float64(m.Time.Unix()))
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import "context"
+
+// Advertisement is a single raw Ruuvi Bluetooth advertisement, before
+// decoding, regardless of how it was captured.
+type Advertisement struct {
+	// MacAddr is the Bluetooth MAC address of the tag that sent the
+	// advertisement, in the same form used as key in GatewayData.Tags.
+	MacAddr string
+	// RSSI is the received signal strength, in dB.
+	RSSI int64
+	// Data is the raw advertisement payload, hex encoded the same way as
+	// GatewayTag.Data, ready to be passed to decodeBluetoothData.
+	Data string
+}
+
+// Source produces Ruuvi Bluetooth advertisements for the server to decode,
+// independently of how they were captured - e.g. Gateway/Station HTTP
+// pushes vs a direct local BLE scan.
+type Source interface {
+	// Run starts the source and blocks, sending advertisements to out,
+	// until ctx is done or an unrecoverable error occurs.
+	Run(ctx context.Context, out chan<- Advertisement) error
+}
+
+// runSource reads advertisements from src until ctx is done, feeding each
+// one through the same decode & export path used for Gateway HTTP pushes.
+func (s *Server) runSource(ctx context.Context, src Source) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	out := make(chan Advertisement, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case adv := <-out:
+				s.handleAdvertisement(adv.MacAddr, adv.RSSI, adv.Data)
+			}
+		}
+	}()
+	err := src.Run(ctx, out)
+	// src.Run can return (with or without an error) without ctx having been
+	// canceled, e.g. if the BLE adapter is missing; cancel runCtx so the
+	// forwarder goroutine above always exits instead of leaking.
+	cancel()
+	<-done
+	return err
+}